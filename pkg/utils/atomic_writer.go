@@ -0,0 +1,198 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ErrSizeExceeded is returned by AtomicWriter.Write once the byte limit set
+// via WithMaxBytes would be exceeded.
+var ErrSizeExceeded = errors.New("utils: atomic writer max bytes exceeded")
+
+// ErrHashMismatch is returned by AtomicWriter.Close when the data written
+// doesn't match the digest set via WithHashVerify; the target file is left
+// untouched.
+var ErrHashMismatch = errors.New("utils: atomic writer hash mismatch")
+
+// AtomicWriter is an io.WriteCloser that streams data into a temp file in
+// the target's directory and, on Close, syncs/chmods/renames it into place
+// using the same temp-file-plus-rename strategy as WriteFileAtomic. Unlike
+// WriteFileAtomic it never buffers the whole payload in memory, so it's
+// suited to large streaming payloads like model manifests or conversation
+// logs.
+//
+// Readers of path see either the previous complete file or the new
+// complete file, never a partial write, for as long as the AtomicWriter
+// hasn't been closed.
+type AtomicWriter struct {
+	path string
+	perm os.FileMode
+	dir  string
+
+	tmpFile *os.File
+	tmpPath string
+
+	maxBytes int64
+	written  int64
+
+	verifyHash  hash.Hash
+	expectedHex string
+
+	sidecarHash hash.Hash
+
+	closed  bool
+	aborted bool
+}
+
+// NewAtomicWriter creates a temp file in the same directory as path and
+// returns an AtomicWriter that streams into it. Call Close to sync, chmod
+// to perm, and atomically rename the temp file into place, or Abort to
+// discard it.
+func NewAtomicWriter(path string, perm os.FileMode) (*AtomicWriter, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(dir, ".tmp-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	return &AtomicWriter{
+		path:    path,
+		perm:    perm,
+		dir:     dir,
+		tmpFile: tmpFile,
+		tmpPath: tmpFile.Name(),
+	}, nil
+}
+
+// WithHashVerify makes Close refuse to rename the temp file into place
+// unless the data written hashes to expectedHex (case-insensitive hex) under h.
+func (w *AtomicWriter) WithHashVerify(h hash.Hash, expectedHex string) *AtomicWriter {
+	w.verifyHash = h
+	w.expectedHex = expectedHex
+	return w
+}
+
+// WithMaxBytes makes Write return ErrSizeExceeded instead of writing past n
+// total bytes, so a runaway stream can't fill the disk.
+func (w *AtomicWriter) WithMaxBytes(n int64) *AtomicWriter {
+	w.maxBytes = n
+	return w
+}
+
+// WithSidecarChecksum makes Close also write a path+".sha256" sidecar file
+// (also atomically) containing the hex SHA-256 digest of the data written,
+// so a future boot can verify persisted state without re-reading the
+// whole file into memory.
+func (w *AtomicWriter) WithSidecarChecksum() *AtomicWriter {
+	w.sidecarHash = sha256.New()
+	return w
+}
+
+// Write implements io.Writer.
+func (w *AtomicWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("utils: atomic writer already closed")
+	}
+	if w.aborted {
+		return 0, errors.New("utils: atomic writer already aborted")
+	}
+
+	if w.maxBytes > 0 && w.written+int64(len(p)) > w.maxBytes {
+		return 0, ErrSizeExceeded
+	}
+
+	n, err := w.tmpFile.Write(p)
+	w.written += int64(n)
+	if n > 0 {
+		if w.verifyHash != nil {
+			w.verifyHash.Write(p[:n])
+		}
+		if w.sidecarHash != nil {
+			w.sidecarHash.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+// Close verifies the configured hash (if any), syncs and chmods the temp
+// file, atomically renames it to path, and writes the checksum sidecar (if
+// configured). On any failure before the rename, the temp file is left in
+// place for a deferred Abort to clean up; path itself is never touched
+// unless the rename has definitely happened.
+func (w *AtomicWriter) Close() error {
+	if w.closed {
+		return errors.New("utils: atomic writer already closed")
+	}
+	if w.aborted {
+		return errors.New("utils: atomic writer already aborted")
+	}
+
+	if w.verifyHash != nil {
+		sum := hex.EncodeToString(w.verifyHash.Sum(nil))
+		if !strings.EqualFold(sum, w.expectedHex) {
+			return fmt.Errorf("%w: got %s, want %s", ErrHashMismatch, sum, w.expectedHex)
+		}
+	}
+
+	if err := w.tmpFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := w.tmpFile.Chmod(w.perm); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := w.tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := renameFile(w.tmpPath, w.path); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("failed to rename temp file: %w", err)
+		}
+		if err := copyThenRename(w.tmpPath, w.path, w.perm); err != nil {
+			return fmt.Errorf("failed to write file across devices: %w", err)
+		}
+	}
+	w.closed = true
+
+	if w.sidecarHash != nil {
+		sum := hex.EncodeToString(w.sidecarHash.Sum(nil))
+		if err := WriteFileAtomic(w.path+".sha256", []byte(sum+"\n"), w.perm); err != nil {
+			return fmt.Errorf("failed to write checksum sidecar: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Abort removes the temp file backing w. It is a no-op if w has already
+// been closed successfully, so `defer w.Abort()` right after NewAtomicWriter
+// is always safe to leave in place alongside an explicit Close call.
+func (w *AtomicWriter) Abort() error {
+	if w.closed || w.aborted {
+		return nil
+	}
+	w.aborted = true
+
+	w.tmpFile.Close()
+	if err := os.Remove(w.tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
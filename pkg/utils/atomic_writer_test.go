@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAtomicWriter_WriteCloseRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	w, err := NewAtomicWriter(path, 0o600)
+	require.NoError(t, err)
+	defer w.Abort()
+
+	_, err = w.Write([]byte("hello, "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(data))
+}
+
+func TestAtomicWriter_AbortRemovesTempFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	w, err := NewAtomicWriter(path, 0o600)
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Abort())
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "target should not have been created")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "temp file should have been removed")
+}
+
+func TestAtomicWriter_AbortAfterCloseIsNoop(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	w, err := NewAtomicWriter(path, 0o600)
+	require.NoError(t, err)
+	defer w.Abort()
+
+	_, err = w.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	require.NoError(t, w.Abort())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+func TestAtomicWriter_HashMismatchRejectsRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	w, err := NewAtomicWriter(path, 0o600)
+	require.NoError(t, err)
+	defer w.Abort()
+
+	w.WithHashVerify(sha256.New(), "deadbeef")
+
+	_, err = w.Write([]byte("data"))
+	require.NoError(t, err)
+
+	err = w.Close()
+	require.ErrorIs(t, err, ErrHashMismatch)
+
+	_, statErr := os.Stat(path)
+	assert.True(t, os.IsNotExist(statErr), "target should not exist after a hash mismatch")
+}
+
+func TestAtomicWriter_HashVerifySucceeds(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	sum := sha256.Sum256([]byte("data"))
+	expected := hex.EncodeToString(sum[:])
+
+	w, err := NewAtomicWriter(path, 0o600)
+	require.NoError(t, err)
+	defer w.Abort()
+
+	w.WithHashVerify(sha256.New(), expected)
+
+	_, err = w.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+func TestAtomicWriter_MaxBytesExceeded(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	w, err := NewAtomicWriter(path, 0o600)
+	require.NoError(t, err)
+	defer w.Abort()
+
+	w.WithMaxBytes(4)
+
+	_, err = w.Write([]byte("too much data"))
+	require.ErrorIs(t, err, ErrSizeExceeded)
+}
+
+func TestAtomicWriter_SidecarChecksum(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	w, err := NewAtomicWriter(path, 0o600)
+	require.NoError(t, err)
+	defer w.Abort()
+
+	w.WithSidecarChecksum()
+
+	_, err = w.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	sidecar, err := os.ReadFile(path + ".sha256")
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte("data"))
+	assert.Equal(t, hex.EncodeToString(sum[:])+"\n", string(sidecar))
+}
+
+func TestAtomicWriter_ConcurrentReadersSeeOldOrNewNeverPartial(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+	require.NoError(t, os.WriteFile(path, []byte("old-complete-content"), 0o600))
+
+	w, err := NewAtomicWriter(path, 0o600)
+	require.NoError(t, err)
+	defer w.Abort()
+
+	_, err = w.Write([]byte("new-complete-content-thats-longer"))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	stop := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					errs <- err
+					return
+				}
+				s := string(data)
+				if s != "old-complete-content" && s != "new-complete-content-thats-longer" {
+					errs <- errors.New("observed a partial/torn write: " + s)
+					return
+				}
+			}
+		}()
+	}
+
+	require.NoError(t, w.Close())
+	close(stop)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new-complete-content-thats-longer", string(data))
+}
+
+func TestAtomicWriter_CrossDeviceFallbackConcurrentReadersNeverPartial(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+	require.NoError(t, os.WriteFile(path, []byte("old-complete-content"), 0o600))
+
+	orig := renameFile
+	renameFile = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { renameFile = orig })
+
+	w, err := NewAtomicWriter(path, 0o600)
+	require.NoError(t, err)
+	defer w.Abort()
+
+	_, err = w.Write([]byte("new-complete-content-thats-longer"))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	stop := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					errs <- err
+					return
+				}
+				s := string(data)
+				if s != "old-complete-content" && s != "new-complete-content-thats-longer" {
+					errs <- errors.New("observed a partial/torn write: " + s)
+					return
+				}
+			}
+		}()
+	}
+
+	require.NoError(t, w.Close())
+	close(stop)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new-complete-content-thats-longer", string(data))
+}
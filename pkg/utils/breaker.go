@@ -0,0 +1,222 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a per-host circuit in a Breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed means requests flow normally and outcomes are tallied.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen means requests are short-circuited with ErrCircuitOpen.
+	BreakerOpen
+	// BreakerHalfOpen means a single probe request is allowed through to
+	// decide whether to close the circuit again or re-open it.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerConfig tunes how a Breaker decides a host is unhealthy and how
+// long it waits before probing it again.
+type BreakerConfig struct {
+	// FailureRatio is the fraction of failures (0-1) within a window of
+	// MinRequests that trips the breaker open. Defaults to 0.5.
+	FailureRatio float64
+
+	// MinRequests is the minimum number of requests evaluated before
+	// FailureRatio is checked at all; a couple of failures on a brand new
+	// host shouldn't open the circuit. Defaults to 5.
+	MinRequests int
+
+	// OpenDuration is how long the breaker stays open before allowing a
+	// single half-open probe request through. Defaults to 30s.
+	OpenDuration time.Duration
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.FailureRatio <= 0 {
+		c.FailureRatio = 0.5
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 5
+	}
+	if c.OpenDuration <= 0 {
+		c.OpenDuration = 30 * time.Second
+	}
+	return c
+}
+
+// Breaker is a per-host circuit breaker consulted by DoRequestWithBreaker
+// (and, via DefaultBreaker, by DoRequestWithRetry). Once a host's failure
+// ratio crosses FailureRatio over a window of MinRequests, the circuit
+// opens and calls to that host fail fast with ErrCircuitOpen for
+// OpenDuration; afterwards a single half-open probe decides whether to
+// close the circuit again or re-open it.
+type Breaker struct {
+	config BreakerConfig
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	state      BreakerState
+	successes  int
+	failures   int
+	openedAt   time.Time
+	probeInUse bool
+	lastErr    error
+}
+
+// NewBreaker returns a Breaker configured with config, filling in unset
+// fields with sensible defaults.
+func NewBreaker(config BreakerConfig) *Breaker {
+	return &Breaker{config: config.withDefaults(), hosts: make(map[string]*hostState)}
+}
+
+// DefaultBreaker is the circuit breaker DoRequestWithRetry consults.
+var DefaultBreaker = NewBreaker(BreakerConfig{})
+
+// ErrCircuitOpen is returned by DoRequestWithBreaker when the circuit for
+// req's host is open, wrapping the last upstream error (if any) that
+// tripped it.
+type ErrCircuitOpen struct {
+	Host string
+	Err  error
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("utils: circuit open for %s", e.Host)
+	}
+	return fmt.Sprintf("utils: circuit open for %s: %v", e.Host, e.Err)
+}
+
+func (e *ErrCircuitOpen) Unwrap() error {
+	return e.Err
+}
+
+// allow reports whether a request to host may proceed and in which state:
+// BreakerClosed runs the full retry policy, BreakerHalfOpen allows exactly
+// one probe attempt through.
+func (b *Breaker) allow(host string) (bool, BreakerState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hs := b.hosts[host]
+	if hs == nil {
+		hs = &hostState{}
+		b.hosts[host] = hs
+	}
+
+	switch hs.state {
+	case BreakerOpen:
+		if time.Since(hs.openedAt) < b.config.OpenDuration {
+			return false, BreakerOpen, hs.lastErr
+		}
+		hs.state = BreakerHalfOpen
+		hs.probeInUse = false
+		fallthrough
+	case BreakerHalfOpen:
+		if hs.probeInUse {
+			return false, BreakerHalfOpen, hs.lastErr
+		}
+		hs.probeInUse = true
+		return true, BreakerHalfOpen, nil
+	default:
+		return true, BreakerClosed, nil
+	}
+}
+
+// recordResult updates the breaker for host based on the outcome of a
+// request that was allowed to proceed while the circuit was in state.
+func (b *Breaker) recordResult(host string, state BreakerState, failed bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hs := b.hosts[host]
+	if hs == nil {
+		return
+	}
+	if failed {
+		hs.lastErr = err
+	}
+
+	switch state {
+	case BreakerHalfOpen:
+		hs.probeInUse = false
+		if failed {
+			hs.state = BreakerOpen
+			hs.openedAt = time.Now()
+		} else {
+			hs.state = BreakerClosed
+		}
+		hs.successes, hs.failures = 0, 0
+
+	default: // BreakerClosed
+		if failed {
+			hs.failures++
+		} else {
+			hs.successes++
+		}
+
+		total := hs.successes + hs.failures
+		if total < b.config.MinRequests {
+			return
+		}
+		if float64(hs.failures)/float64(total) >= b.config.FailureRatio {
+			hs.state = BreakerOpen
+			hs.openedAt = time.Now()
+		}
+		hs.successes, hs.failures = 0, 0
+	}
+}
+
+// DoRequestWithBreaker runs DoRequestWithPolicy under DefaultRetryPolicy,
+// gated by breaker: if the circuit for req.URL.Host is open, the call
+// short-circuits immediately with ErrCircuitOpen and spends no attempts;
+// if half-open, exactly one probe attempt is allowed through and its
+// outcome decides whether the circuit closes or re-opens. Only connection
+// errors and 5xx responses count as failures; 4xx responses don't trip
+// the breaker.
+func DoRequestWithBreaker(client *http.Client, req *http.Request, breaker *Breaker) (*http.Response, error) {
+	host := req.URL.Host
+
+	allowed, state, lastErr := breaker.allow(host)
+	if !allowed {
+		return nil, &ErrCircuitOpen{Host: host, Err: lastErr}
+	}
+
+	policy := DefaultRetryPolicy()
+	if state == BreakerHalfOpen {
+		policy.MaxAttempts = 1
+	}
+
+	resp, err := DoRequestWithPolicy(client, req, policy)
+
+	failed := err != nil || (resp != nil && resp.StatusCode >= http.StatusInternalServerError)
+	breaker.recordResult(host, state, failed, err)
+
+	return resp, err
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyServer is an httptest.Server whose handler can be toggled between
+// always-healthy and always-failing, with a request counter.
+type flakyServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	healthy  bool
+	requests int
+}
+
+func newFlakyServer() *flakyServer {
+	fs := &flakyServer{healthy: true}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		fs.requests++
+		healthy := fs.healthy
+		fs.mu.Unlock()
+
+		if healthy {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	return fs
+}
+
+func (fs *flakyServer) setHealthy(healthy bool) {
+	fs.mu.Lock()
+	fs.healthy = healthy
+	fs.mu.Unlock()
+}
+
+func (fs *flakyServer) requestCount() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.requests
+}
+
+func TestBreaker_OpensAfterFailureRatioThenProbes(t *testing.T) {
+	retryDelayUnit = time.Millisecond
+	t.Cleanup(func() { retryDelayUnit = time.Second })
+
+	server := newFlakyServer()
+	defer server.Close()
+	server.setHealthy(false)
+
+	breaker := NewBreaker(BreakerConfig{FailureRatio: 0.5, MinRequests: 2, OpenDuration: 30 * time.Millisecond})
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	newReq := func() *http.Request {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		return req
+	}
+
+	// Two failing calls trip the breaker open (ratio 2/2 >= 0.5).
+	for i := 0; i < 2; i++ {
+		resp, err := DoRequestWithBreaker(client, newReq(), breaker)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	countBeforeOpen := server.requestCount()
+
+	// While open, the call must short-circuit without reaching the server.
+	_, err := DoRequestWithBreaker(client, newReq(), breaker)
+	var circuitErr *ErrCircuitOpen
+	require.ErrorAs(t, err, &circuitErr)
+	assert.Equal(t, countBeforeOpen, server.requestCount(), "open breaker must not spend any attempts")
+
+	// After OpenDuration, a single half-open probe is allowed; server is
+	// still unhealthy so the circuit re-opens.
+	time.Sleep(40 * time.Millisecond)
+	resp, err := DoRequestWithBreaker(client, newReq(), breaker)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	resp.Body.Close()
+
+	_, err = DoRequestWithBreaker(client, newReq(), breaker)
+	require.ErrorAs(t, err, &circuitErr)
+
+	// Once the server recovers, the next half-open probe closes the circuit.
+	server.setHealthy(true)
+	time.Sleep(40 * time.Millisecond)
+	resp, err = DoRequestWithBreaker(client, newReq(), breaker)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = DoRequestWithBreaker(client, newReq(), breaker)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestBreaker_ClientErrorsDoNotTripBreaker(t *testing.T) {
+	retryDelayUnit = time.Millisecond
+	t.Cleanup(func() { retryDelayUnit = time.Second })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	breaker := NewBreaker(BreakerConfig{FailureRatio: 0.5, MinRequests: 2, OpenDuration: time.Minute})
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	for i := 0; i < 5; i++ {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+
+		resp, err := DoRequestWithBreaker(client, req, breaker)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp, err := DoRequestWithBreaker(client, req, breaker)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "4xx responses must never open the circuit")
+	resp.Body.Close()
+}
+
+func TestErrCircuitOpen_WrapsLastError(t *testing.T) {
+	upstream := errors.New("boom")
+	err := &ErrCircuitOpen{Host: "example.com", Err: upstream}
+	assert.ErrorIs(t, err, upstream)
+	assert.Contains(t, err.Error(), "example.com")
+}
@@ -7,11 +7,40 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"syscall"
 )
 
+// renameFile is os.Rename, indirected so tests can simulate rename failures
+// (e.g. EXDEV) without needing an actual cross-filesystem setup.
+var renameFile = os.Rename
+
+// WriteFileAtomicOptions configures the extra safety knobs of
+// WriteFileAtomicWithOptions beyond the temp-file-plus-rename basics that
+// WriteFileAtomic already applies unconditionally.
+type WriteFileAtomicOptions struct {
+	// SyncDir fsyncs the parent directory after the rename. This is what
+	// makes the rename itself crash-safe on ext4/xfs and most flash
+	// filesystems; without it, a power loss right after rename but before
+	// the directory's inode is flushed can lose the rename entirely.
+	SyncDir bool
+
+	// PreservePermissions chmods (and, where supported, chowns) the new
+	// file to match the mode/owner of the file it replaces, instead of
+	// using the perm argument. Has no effect if path doesn't already exist.
+	PreservePermissions bool
+
+	// Backup renames any existing file at path to path+".bak" immediately
+	// before the atomic swap, so a corrupt new write can still be
+	// recovered from the previous version.
+	Backup bool
+}
+
 // WriteFileAtomic atomically writes data to a file using a temp file + rename pattern.
 //
 // This guarantees that the target file is either:
@@ -24,6 +53,7 @@ import (
 // 3. Syncs to disk (critical for SD cards/flash storage)
 // 4. Sets file permissions
 // 5. Atomically renames temp file to target path
+// 6. Syncs the parent directory, so the rename survives a power loss
 //
 // Parameters:
 //   - path: Target file path
@@ -41,6 +71,13 @@ import (
 //	// Public readable file
 //	err := utils.WriteFileAtomic("public.txt", data, 0o644)
 func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return WriteFileAtomicWithOptions(path, data, perm, WriteFileAtomicOptions{SyncDir: true})
+}
+
+// WriteFileAtomicWithOptions is WriteFileAtomic with additional control over
+// directory syncing, permission preservation, and backing up the file being
+// replaced. See WriteFileAtomicOptions for details on each knob.
+func WriteFileAtomicWithOptions(path string, data []byte, perm os.FileMode, opts WriteFileAtomicOptions) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
@@ -75,8 +112,13 @@ func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
 		return fmt.Errorf("failed to sync temp file: %w", err)
 	}
 
-	// Set file permissions
-	if err := tmpFile.Chmod(perm); err != nil {
+	// Set file permissions, either from perm or from the file being replaced
+	if opts.PreservePermissions {
+		if err := preserveExistingPermissions(tmpFile, path, perm); err != nil {
+			tmpFile.Close()
+			return fmt.Errorf("failed to preserve permissions: %w", err)
+		}
+	} else if err := tmpFile.Chmod(perm); err != nil {
 		tmpFile.Close()
 		return fmt.Errorf("failed to set permissions: %w", err)
 	}
@@ -86,12 +128,160 @@ func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
 		return fmt.Errorf("failed to close temp file: %w", err)
 	}
 
+	backedUp := false
+	if opts.Backup {
+		didBackup, err := backupExisting(path)
+		if err != nil {
+			return fmt.Errorf("failed to back up existing file: %w", err)
+		}
+		backedUp = didBackup
+	}
+
 	// Atomic rename: temp file becomes the target
-	if err := os.Rename(tmpPath, path); err != nil {
-		return fmt.Errorf("failed to rename temp file: %w", err)
+	if err := renameFile(tmpPath, path); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return renameFailureErr(backedUp, path, fmt.Errorf("failed to rename temp file: %w", err))
+		}
+		// tmpPath and path are on different filesystems (e.g. path crosses
+		// a bind mount): fall back to copying the data into place directly,
+		// keeping the mode already applied to the temp file.
+		mode := perm
+		if info, err := os.Stat(tmpPath); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := copyThenRename(tmpPath, path, mode); err != nil {
+			return renameFailureErr(backedUp, path, fmt.Errorf("failed to write file across devices: %w", err))
+		}
+	}
+
+	if opts.SyncDir {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("failed to sync directory: %w", err)
+		}
 	}
 
 	// Success: skip cleanup
 	cleanup = false
 	return nil
 }
+
+// preserveExistingPermissions chmods (and, on platforms that expose a
+// uid/gid, chowns) tmpFile to match the file currently at path. If path
+// doesn't exist yet, there's nothing to preserve, so it falls back to perm.
+func preserveExistingPermissions(tmpFile *os.File, path string, perm os.FileMode) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tmpFile.Chmod(perm)
+		}
+		return err
+	}
+
+	if err := tmpFile.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		// Best-effort: chown requires privileges we may not have.
+		_ = tmpFile.Chown(int(stat.Uid), int(stat.Gid))
+	}
+
+	return nil
+}
+
+// backupExisting renames any file already at path to path+".bak", reporting
+// whether a backup was actually made. It is a no-op if path doesn't exist.
+func backupExisting(path string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := os.Rename(path, path+".bak"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// renameFailureErr wraps origErr and, if a backup was made, restores
+// path+".bak" back to path so a failed rename never leaves path missing
+// entirely. If the restore itself fails, that failure is appended to the
+// returned error rather than swallowed.
+func renameFailureErr(backedUp bool, path string, origErr error) error {
+	if !backedUp {
+		return origErr
+	}
+	if restoreErr := os.Rename(path+".bak", path); restoreErr != nil {
+		return fmt.Errorf("%w (additionally failed to restore backup: %v)", origErr, restoreErr)
+	}
+	return origErr
+}
+
+// copyThenRename handles the os.Rename EXDEV case: tmpPath and path live on
+// different filesystems, so a direct rename isn't possible. Copying
+// straight into path would leave a torn file visible to concurrent readers
+// for the duration of the copy, so instead this copies tmpPath's contents
+// into a new temp file created alongside path (i.e. on path's filesystem),
+// syncs and chmods that, and renames it onto path — a same-filesystem
+// rename, which is atomic — before removing the original cross-device
+// temp file.
+func copyThenRename(tmpPath, path string, perm os.FileMode) error {
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	localTmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*.tmp")
+	if err != nil {
+		return err
+	}
+	localTmpPath := localTmp.Name()
+	cleanup := true
+	defer func() {
+		if cleanup {
+			_ = os.Remove(localTmpPath)
+		}
+	}()
+
+	if _, err := io.Copy(localTmp, src); err != nil {
+		localTmp.Close()
+		return err
+	}
+	if err := localTmp.Sync(); err != nil {
+		localTmp.Close()
+		return err
+	}
+	if err := localTmp.Chmod(perm); err != nil {
+		localTmp.Close()
+		return err
+	}
+	if err := localTmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(localTmpPath, path); err != nil {
+		return err
+	}
+	cleanup = false
+
+	return os.Remove(tmpPath)
+}
+
+// syncDir fsyncs dir so a preceding rename within it is durable across a
+// power loss. Directory fsync isn't meaningful on Windows, so it's a no-op
+// there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic_WritesAndSyncsDir(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	require.NoError(t, WriteFileAtomic(path, []byte("hello"), 0o600))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// No leftover temp files.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteFileAtomicWithOptions_CrossDeviceFallback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	orig := renameFile
+	renameFile = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { renameFile = orig })
+
+	require.NoError(t, WriteFileAtomicWithOptions(path, []byte("hello"), 0o644, WriteFileAtomicOptions{SyncDir: true}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// The rename "failed" every time, so the temp file should have been
+	// cleaned up by the copy-then-rename fallback rather than left behind.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteFileAtomicWithOptions_CrossDeviceFallbackNeverPartial(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	oldContent := bytes.Repeat([]byte("o"), 1024)
+	newContent := bytes.Repeat([]byte("n"), 5*1024*1024)
+	require.NoError(t, os.WriteFile(path, oldContent, 0o644))
+
+	orig := renameFile
+	renameFile = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { renameFile = orig })
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	stop := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(data, oldContent) && !bytes.Equal(data, newContent) {
+					errs <- fmt.Errorf("observed a partial/torn write of length %d", len(data))
+					return
+				}
+			}
+		}()
+	}
+
+	err := WriteFileAtomicWithOptions(path, newContent, 0o644, WriteFileAtomicOptions{SyncDir: true})
+	close(stop)
+	wg.Wait()
+	close(errs)
+
+	require.NoError(t, err)
+	for e := range errs {
+		t.Fatal(e)
+	}
+
+	data, readErr := os.ReadFile(path)
+	require.NoError(t, readErr)
+	assert.Equal(t, newContent, data)
+}
+
+func TestWriteFileAtomicWithOptions_RenameErrorPropagates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	orig := renameFile
+	renameFile = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+	}
+	t.Cleanup(func() { renameFile = orig })
+
+	err := WriteFileAtomicWithOptions(path, []byte("hello"), 0o644, WriteFileAtomicOptions{SyncDir: true})
+	require.Error(t, err)
+
+	_, statErr := os.Stat(path)
+	assert.True(t, os.IsNotExist(statErr), "target should not exist after a non-EXDEV rename failure")
+}
+
+func TestWriteFileAtomicWithOptions_PreservePermissions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0o640))
+
+	opts := WriteFileAtomicOptions{SyncDir: true, PreservePermissions: true}
+	require.NoError(t, WriteFileAtomicWithOptions(path, []byte("new"), 0o600, opts))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm(), "should keep the replaced file's mode, not perm")
+}
+
+func TestWriteFileAtomicWithOptions_PreservePermissionsNoExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	opts := WriteFileAtomicOptions{SyncDir: true, PreservePermissions: true}
+	require.NoError(t, WriteFileAtomicWithOptions(path, []byte("new"), 0o644, opts))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm(), "should fall back to perm when there's nothing to preserve")
+}
+
+func TestWriteFileAtomicWithOptions_Backup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0o644))
+
+	opts := WriteFileAtomicOptions{SyncDir: true, Backup: true}
+	require.NoError(t, WriteFileAtomicWithOptions(path, []byte("new"), 0o644, opts))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	backup, err := os.ReadFile(path + ".bak")
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(backup))
+}
+
+func TestWriteFileAtomicWithOptions_BackupRestoredOnRenameFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0o644))
+
+	orig := renameFile
+	renameFile = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+	}
+	t.Cleanup(func() { renameFile = orig })
+
+	opts := WriteFileAtomicOptions{SyncDir: true, Backup: true}
+	err := WriteFileAtomicWithOptions(path, []byte("new"), 0o644, opts)
+	require.Error(t, err)
+
+	// The backed-up original must be restored rather than leaving path
+	// missing entirely.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+
+	_, statErr := os.Stat(path + ".bak")
+	assert.True(t, os.IsNotExist(statErr), "the .bak file should have been moved back into place")
+}
+
+func TestWriteFileAtomicWithOptions_BackupNoExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	opts := WriteFileAtomicOptions{SyncDir: true, Backup: true}
+	require.NoError(t, WriteFileAtomicWithOptions(path, []byte("new"), 0o644, opts))
+
+	_, err := os.Stat(path + ".bak")
+	assert.True(t, os.IsNotExist(err), "no backup should be created when there was nothing to back up")
+}
@@ -0,0 +1,290 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryDelayUnit is the base unit used to space out retry attempts.
+// It is a package-level var (rather than a const) so tests can shrink it
+// to avoid slow sleeps.
+var retryDelayUnit = time.Second
+
+// maxRetryAttempts is the default number of times a request is attempted,
+// including the first try.
+const maxRetryAttempts = 3
+
+// idempotencyKeyHeader lets a caller mark an otherwise non-idempotent
+// request (POST/PATCH) as safe to retry, e.g. because the upstream API
+// deduplicates on this key.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// RetryPolicy controls how DoRequestWithPolicy retries a request: how many
+// times, how long to wait between attempts, and which responses/errors
+// warrant another attempt at all.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Values <= 1 disable retrying entirely.
+	MaxAttempts int
+
+	// MinWait and MaxWait bound the delay between attempts; Backoff is
+	// expected to stay within this range.
+	MinWait time.Duration
+	MaxWait time.Duration
+
+	// Backoff computes the delay before the next attempt. resp is the
+	// response from the attempt that just finished (nil on a transport
+	// error). Defaults to ExponentialJitterBackoff.
+	Backoff func(attempt int, min, max time.Duration, resp *http.Response) time.Duration
+
+	// CheckRetry decides whether the attempt that just finished should be
+	// retried. Defaults to DefaultCheckRetry. A non-nil error aborts the
+	// loop immediately, replacing whatever error the attempt produced.
+	CheckRetry func(ctx context.Context, resp *http.Response, err error) (bool, error)
+
+	// AllowNonIdempotentRetry opts a caller into retrying POST/PATCH
+	// requests that don't carry an Idempotency-Key header. Off by default
+	// because replaying a non-idempotent request that may have partially
+	// succeeded upstream can duplicate side effects.
+	AllowNonIdempotentRetry bool
+}
+
+// DefaultRetryPolicy returns the policy used by DoRequestWithRetry: up to
+// maxRetryAttempts tries, spaced by ExponentialJitterBackoff between
+// retryDelayUnit and 30x that, retrying connection errors, 429s and 5xx
+// responses other than 501 per DefaultCheckRetry.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: maxRetryAttempts,
+		MinWait:     retryDelayUnit,
+		MaxWait:     retryDelayUnit * 30,
+		Backoff:     ExponentialJitterBackoff,
+		CheckRetry:  DefaultCheckRetry,
+	}
+}
+
+// DoRequestWithRetry executes req using client under DefaultRetryPolicy,
+// consulting DefaultBreaker so a host that's persistently failing gets
+// short-circuited with ErrCircuitOpen instead of spending a fresh retry
+// budget on every call. See DoRequestWithBreaker for the exact semantics.
+//
+// The response from the last attempt is returned alongside whatever error
+// that attempt produced; a non-nil response is handed back even after the
+// retry budget is exhausted so callers can inspect the status code/body.
+//
+// To also cancel attempts that stop making upload/download progress
+// (e.g. a stalled body on a flaky connection), pass a client produced by
+// NewWatchdogClient instead of a plain *http.Client; every retry attempt
+// will then be subject to the same stall detection.
+func DoRequestWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	return DoRequestWithBreaker(client, req, DefaultBreaker)
+}
+
+// DoRequestWithPolicy executes req using client, retrying according to
+// policy. Request bodies are snapshotted via req.GetBody (buffering the
+// body once on first use if GetBody isn't already set) so they can be
+// replayed across attempts.
+//
+// If req.Context() is canceled while waiting between attempts, the last
+// response body is closed and the context error is returned.
+func DoRequestWithPolicy(client *http.Client, req *http.Request, policy RetryPolicy) (*http.Response, error) {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Backoff == nil {
+		policy.Backoff = ExponentialJitterBackoff
+	}
+	if policy.CheckRetry == nil {
+		policy.CheckRetry = DefaultCheckRetry
+	}
+
+	if err := prepareRequestBody(req); err != nil {
+		return nil, err
+	}
+
+	retriable := policy.MaxAttempts > 1 &&
+		(!isNonIdempotentMethod(req.Method) || req.Header.Get(idempotencyKeyHeader) != "" || policy.AllowNonIdempotentRetry)
+
+	var resp *http.Response
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req.Body = body
+			}
+		}
+
+		var err error
+		resp, err = client.Do(req)
+
+		if !retriable || attempt == policy.MaxAttempts {
+			return resp, err
+		}
+
+		shouldRetry, checkErr := policy.CheckRetry(req.Context(), resp, err)
+		if checkErr != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, checkErr
+		}
+		if !shouldRetry {
+			return resp, err
+		}
+
+		wait := policy.Backoff(attempt, policy.MinWait, policy.MaxWait, resp)
+		if werr := sleepWithCtx(req.Context(), wait); werr != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, werr
+		}
+	}
+
+	return resp, nil
+}
+
+// DefaultCheckRetry retries connection errors and 429/5xx responses
+// (except 501 Not Implemented, which won't succeed on retry). It stops
+// retrying once req's context has already been canceled.
+func DefaultCheckRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if resp == nil {
+		return false, nil
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotImplemented:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true, nil
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// ExponentialJitterBackoff waits min(max, min*2^attempt), then scales that
+// by a uniform random factor in [0.5, 1.0) so that multiple picoclaw
+// devices retrying the same upstream don't all wake up in lockstep.
+//
+// When resp carries a 429 or 503 with a Retry-After header that parses to
+// something shorter than max, that value is honored instead.
+func ExponentialJitterBackoff(attempt int, min, max time.Duration, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok && wait < max {
+			return wait
+		}
+	}
+
+	shift := attempt
+	if shift > 62 {
+		shift = 62 // guard against overflowing the time.Duration shift
+	}
+	wait := min * time.Duration(int64(1)<<uint(shift))
+	if wait <= 0 || wait > max {
+		wait = max
+	}
+
+	wait = time.Duration(float64(wait) * (0.5 + rand.Float64()*0.5))
+	if wait < min {
+		wait = min
+	}
+	return wait
+}
+
+// parseRetryAfter parses a Retry-After header value, which per RFC 9110
+// is either a number of delta-seconds or an HTTP-date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			secs = 0
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if wait := time.Until(t); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// isNonIdempotentMethod reports whether method may have side effects that
+// shouldn't be repeated by a blind retry.
+func isNonIdempotentMethod(method string) bool {
+	return method == http.MethodPost || method == http.MethodPatch
+}
+
+// prepareRequestBody ensures req.GetBody is set so the body can be
+// replayed across retry attempts, buffering it once if the caller didn't
+// already provide one (as http.NewRequest does for *bytes.Buffer,
+// *bytes.Reader and *strings.Reader bodies, but not for an arbitrary
+// io.Reader).
+func prepareRequestBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to buffer request body for retry: %w", err)
+	}
+
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return nil
+}
+
+// sleepWithCtx blocks for d, returning early with ctx.Err() if ctx is
+// canceled first.
+func sleepWithCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -204,3 +206,165 @@ func TestDoRequestWithRetry_Delay(t *testing.T) {
 
 	assert.GreaterOrEqual(t, delays[2], time.Millisecond)
 }
+
+// flakyTransport fails the first failUntil round trips with a raw transport
+// error (no response at all) before delegating to next.
+type flakyTransport struct {
+	next      http.RoundTripper
+	failUntil int
+	attempts  int
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.attempts++
+	if f.attempts <= f.failUntil {
+		return nil, errors.New("connection refused")
+	}
+	return f.next.RoundTrip(req)
+}
+
+func TestDoRequestWithRetry_RetriesGenuineTransportError(t *testing.T) {
+	retryDelayUnit = time.Millisecond
+	t.Cleanup(func() { retryDelayUnit = time.Second })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	transport := &flakyTransport{next: http.DefaultTransport, failUntil: 1}
+	client := &http.Client{Timeout: 5 * time.Second, Transport: transport}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := DoRequestWithRetry(client, req)
+	require.NoError(t, err, "a connection error on an early attempt must not panic or abort the retry loop")
+	require.NotNil(t, resp)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, transport.attempts)
+}
+
+func TestExponentialJitterBackoff_Bounds(t *testing.T) {
+	min := 10 * time.Millisecond
+	max := 1 * time.Second
+
+	for attempt := 1; attempt <= 6; attempt++ {
+		for i := 0; i < 50; i++ {
+			wait := ExponentialJitterBackoff(attempt, min, max, nil)
+			assert.GreaterOrEqual(t, wait, min)
+			assert.LessOrEqual(t, wait, max)
+		}
+	}
+}
+
+func TestExponentialJitterBackoff_HonorsRetryAfterDeltaSeconds(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"2"}},
+	}
+
+	wait := ExponentialJitterBackoff(5, time.Millisecond, time.Minute, resp)
+	assert.Equal(t, 2*time.Second, wait)
+}
+
+func TestExponentialJitterBackoff_HonorsRetryAfterHTTPDate(t *testing.T) {
+	future := time.Now().Add(3 * time.Second).UTC().Format(http.TimeFormat)
+	resp := &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{"Retry-After": []string{future}},
+	}
+
+	wait := ExponentialJitterBackoff(1, time.Millisecond, time.Minute, resp)
+	assert.InDelta(t, 3*time.Second, wait, float64(time.Second))
+}
+
+func TestExponentialJitterBackoff_IgnoresRetryAfterLongerThanMaxWait(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"3600"}},
+	}
+
+	wait := ExponentialJitterBackoff(1, time.Millisecond, time.Second, resp)
+	assert.LessOrEqual(t, wait, time.Second)
+}
+
+func TestDoRequestWithPolicy_DoesNotRetryPOSTWithoutIdempotencyKey(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewBufferString("payload"))
+	require.NoError(t, err)
+
+	policy := DefaultRetryPolicy()
+	policy.MinWait = time.Millisecond
+	resp, err := DoRequestWithPolicy(client, req, policy)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, 1, attempts, "POST without an Idempotency-Key must not be retried")
+}
+
+func TestDoRequestWithPolicy_RetriesPOSTWithIdempotencyKey(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewBufferString("payload"))
+	require.NoError(t, err)
+	req.Header.Set("Idempotency-Key", "retry-test-key")
+
+	policy := DefaultRetryPolicy()
+	policy.MinWait = time.Millisecond
+	resp, err := DoRequestWithPolicy(client, req, policy)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, 2, attempts)
+}
+
+func TestDoRequestWithPolicy_ReplaysBodyAcrossAttempts(t *testing.T) {
+	var gotBodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBodies = append(gotBodies, string(body))
+		if len(gotBodies) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("hello-body"))
+	require.NoError(t, err)
+	req.Header.Set("Idempotency-Key", "replay-test-key")
+
+	policy := DefaultRetryPolicy()
+	policy.MinWait = time.Millisecond
+	resp, err := DoRequestWithPolicy(client, req, policy)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Len(t, gotBodies, 3)
+	for _, body := range gotBodies {
+		assert.Equal(t, "hello-body", body)
+	}
+}
@@ -0,0 +1,216 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package utils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WatchdogTransport is an http.RoundTripper that cancels a request when its
+// body stops making upload or download progress for longer than Timeout.
+//
+// This is distinct from http.Client.Timeout, which bounds the whole
+// request/response lifecycle: a flaky Wi-Fi or SD-card-backed connection
+// can sit idle mid-body for minutes without the underlying TCP connection
+// ever breaking, and a large Client.Timeout meant to allow for slow-but-
+// progressing transfers won't catch that. WatchdogTransport instead
+// tracks the time of the last successful Read/Write on the request and
+// response bodies and cancels the request's context if that goes stale.
+//
+// Optionally, FirstByteTimeout bounds how long we wait for the first byte
+// of the response body after the request is sent, independent of Timeout
+// (a server that accepts the connection but never responds can otherwise
+// survive as long as Timeout allows).
+type WatchdogTransport struct {
+	// Next is the underlying RoundTripper. Defaults to http.DefaultTransport.
+	Next http.RoundTripper
+
+	// Timeout is the maximum time allowed between successful reads/writes
+	// on the request or response body before the request is canceled.
+	// A zero value disables stall detection entirely.
+	Timeout time.Duration
+
+	// FirstByteTimeout, if set, bounds how long we wait for the first byte
+	// of the response body after the request is sent. Zero disables it. It
+	// has no effect when Timeout is zero, since the transport then passes
+	// requests straight through to Next.
+	FirstByteTimeout time.Duration
+}
+
+// NewWatchdogTransport returns a WatchdogTransport wrapping next (which may
+// be nil to use http.DefaultTransport) with the given stall timeout.
+func NewWatchdogTransport(next http.RoundTripper, timeout time.Duration) *WatchdogTransport {
+	return &WatchdogTransport{Next: next, Timeout: timeout}
+}
+
+// NewWatchdogClient returns a shallow copy of client with its Transport
+// wrapped in a WatchdogTransport, so that DoRequestWithRetry (and any other
+// caller that just takes an *http.Client) benefits from stall detection on
+// every attempt without changing call sites.
+func NewWatchdogClient(client *http.Client, timeout, firstByteTimeout time.Duration) *http.Client {
+	wrapped := *client
+	wrapped.Transport = &WatchdogTransport{
+		Next:             client.Transport,
+		Timeout:          timeout,
+		FirstByteTimeout: firstByteTimeout,
+	}
+	return &wrapped
+}
+
+// activityTracker records the last time progress was made on a request's
+// body (upload) or response's body (download), plus whether the first
+// response byte has been seen yet.
+type activityTracker struct {
+	mu           sync.Mutex
+	lastActivity time.Time
+	firstByteAt  time.Time
+}
+
+func newActivityTracker() *activityTracker {
+	return &activityTracker{lastActivity: time.Now()}
+}
+
+func (a *activityTracker) touch() {
+	a.mu.Lock()
+	a.lastActivity = time.Now()
+	a.mu.Unlock()
+}
+
+func (a *activityTracker) idleFor() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Since(a.lastActivity)
+}
+
+func (a *activityTracker) markFirstByte() {
+	a.mu.Lock()
+	if a.firstByteAt.IsZero() {
+		a.firstByteAt = time.Now()
+	}
+	a.mu.Unlock()
+}
+
+func (a *activityTracker) sawFirstByte() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !a.firstByteAt.IsZero()
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *WatchdogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if t.Timeout <= 0 {
+		return next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.Clone(ctx)
+
+	tracker := newActivityTracker()
+	if req.Body != nil {
+		req.Body = &watchdogReader{ReadCloser: req.Body, tracker: tracker}
+	}
+
+	done := make(chan struct{})
+	sentAt := time.Now()
+	go t.watch(tracker, cancel, done, sentAt)
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		cancel()
+		close(done)
+		return nil, err
+	}
+
+	resp.Body = &watchdogBody{ReadCloser: resp.Body, tracker: tracker, done: done, cancel: cancel}
+	return resp, nil
+}
+
+// watch ticks at min(Timeout/4, 1s), or at FirstByteTimeout/4 when that is
+// set and shorter, and cancels the request once either the
+// first-byte deadline or the general stall timeout is exceeded. It exits as
+// soon as done is closed, which happens when the response body is closed or
+// the round trip fails outright.
+func (t *WatchdogTransport) watch(tracker *activityTracker, cancel context.CancelFunc, done <-chan struct{}, sentAt time.Time) {
+	interval := t.Timeout / 4
+	if interval <= 0 || interval > time.Second {
+		interval = time.Second
+	}
+	if t.FirstByteTimeout > 0 {
+		if fbInterval := t.FirstByteTimeout / 4; fbInterval > 0 && fbInterval < interval {
+			interval = fbInterval
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if t.FirstByteTimeout > 0 && !tracker.sawFirstByte() && time.Since(sentAt) > t.FirstByteTimeout {
+				cancel()
+				return
+			}
+			if tracker.idleFor() > t.Timeout {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// watchdogReader wraps a request body, recording upload progress.
+type watchdogReader struct {
+	io.ReadCloser
+	tracker *activityTracker
+}
+
+func (w *watchdogReader) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	if n > 0 {
+		w.tracker.touch()
+	}
+	return n, err
+}
+
+// watchdogBody wraps a response body, recording download progress and
+// signaling the watch goroutine to stop once the body is closed.
+type watchdogBody struct {
+	io.ReadCloser
+	tracker   *activityTracker
+	done      chan struct{}
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+func (w *watchdogBody) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	if n > 0 {
+		w.tracker.touch()
+		w.tracker.markFirstByte()
+	}
+	return n, err
+}
+
+func (w *watchdogBody) Close() error {
+	err := w.ReadCloser.Close()
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.cancel()
+	})
+	return err
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchdogTransport_CancelsOnStall(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("first-chunk"))
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("second-chunk"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: NewWatchdogTransport(http.DefaultTransport, 20*time.Millisecond),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	_, err = io.ReadAll(resp.Body)
+	require.Error(t, err, "expected a read error once the stall timeout cancels the request")
+}
+
+func TestWatchdogTransport_NoStallSucceeds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: NewWatchdogTransport(http.DefaultTransport, time.Second),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestWatchdogTransport_FirstByteTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("too-late"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &WatchdogTransport{
+			Next:             http.DefaultTransport,
+			Timeout:          time.Second,
+			FirstByteTimeout: 20 * time.Millisecond,
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	_, err = client.Do(req)
+	require.Error(t, err, "expected the request to be canceled before the first byte arrived")
+}
+
+func TestWatchdogTransport_GoroutineExitsOnClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: NewWatchdogTransport(http.DefaultTransport, 50*time.Millisecond),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// Closing the body closes the done channel that stops the watch
+	// goroutine. Wait past the stall timeout and make sure a second request
+	// through the same client still succeeds with its own watchdog.
+	time.Sleep(100 * time.Millisecond)
+
+	req2, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp2, err := client.Do(req2)
+	require.NoError(t, err)
+	defer resp2.Body.Close()
+	body, err := io.ReadAll(resp2.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+}